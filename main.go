@@ -1,95 +1,275 @@
 package main
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/gocolly/colly"
 	"github.com/spf13/viper"
-	"github.com/studio-b12/gowebdav"
 )
 
+// DefaultChunkSize is the chunk size used when streaming an upload in
+// pieces (Nextcloud chunked PUTs, TUS PATCHes) when ScrapperConfig.ChunkSize
+// is left unset.
+const DefaultChunkSize = 10 * 1024 * 1024 // 10 MiB
+
+// DefaultParallelism is the number of concurrent Download calls DownloadAll
+// runs when ScrapperConfig.Parallelism is left unset.
+const DefaultParallelism = 4
+
+// MaxDownloadAttempts is how many times in total DownloadAll attempts a
+// single recipe download when it keeps failing with a transient (5xx or
+// network) error.
+const MaxDownloadAttempts = 3
+
 type Scrapper struct {
-	url             string
-	c               *colly.Collector
-	dav             *gowebdav.Client
+	sources         []SourceConfig
 	client          *http.Client
+	uploader        Uploader
 	davFolder       string
 	davFolderFormat string
+	parallelism     int
+	ratePerHost     float64
+
+	manifestOnce sync.Once
+	manifest     *Manifest
+	manifestErr  error
 }
 
 type ScrapperConfig struct {
 	ScrapperUrl     string
+	Sources         []SourceConfig
 	DavUrl          string
 	DavUsername     string
 	DavPassword     string
 	DavFolder       string
 	DavFolderFormat string
 	HttpTimeout     time.Duration
+	ChunkSize       int64
+	UploaderType    string
+	TusEndpoint     string
+	TusStateFile    string
+	Parallelism     int
+	RatePerHost     float64
 }
 
 func NewScrapper(config ScrapperConfig) (*Scrapper, error) {
-	dav := gowebdav.NewClient(config.DavUrl, config.DavUsername, config.DavPassword)
+	chunkSize := config.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	var uploader Uploader
+	var err error
+	switch config.UploaderType {
+	case "", "webdav":
+		uploader, err = NewWebDAVUploader(config.DavUrl, config.DavUsername, config.DavPassword, config.HttpTimeout, chunkSize)
+	case "tus":
+		uploader, err = NewTUSUploader(config.TusEndpoint, config.HttpTimeout, chunkSize, config.TusStateFile)
+	default:
+		err = fmt.Errorf("unknown uploader type: %s", config.UploaderType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parallelism := config.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
 	scrapper := Scrapper{
-		url:             config.ScrapperUrl,
-		c:               colly.NewCollector(),
-		dav:             dav,
+		uploader:        uploader,
 		davFolder:       config.DavFolder,
 		davFolderFormat: config.DavFolderFormat,
+		parallelism:     parallelism,
+		ratePerHost:     config.RatePerHost,
 		client: &http.Client{
 			Timeout: config.HttpTimeout,
 		},
 	}
 
-	err := scrapper.dav.Connect()
-	if err != nil {
-		return nil, err
+	sources := config.Sources
+	if len(sources) == 0 && config.ScrapperUrl != "" {
+		// Legacy single-source configuration: reproduce the historical
+		// hardcoded HelloFresh rule as the default (and only) source.
+		sources = []SourceConfig{defaultHelloFreshSource(config.ScrapperUrl)}
+	}
+	for _, source := range sources {
+		if err := scrapper.AddSource(source); err != nil {
+			return nil, err
+		}
 	}
 
 	return &scrapper, nil
 }
 
-func (s *Scrapper) Scrape() []string {
-	var url []string
+func (s *Scrapper) Download(sourceName, u, filename string) error {
+	davFolder := path.Join(s.davFolder, time.Now().Format(s.davFolderFormat), sourceName)
+	if err := s.uploader.EnsureFolder(davFolder); err != nil {
+		return err
+	}
+
+	davFilePath := path.Join(davFolder, filename)
+
+	store, dedupable := s.uploader.(ContentAddressableStore)
+	if !dedupable {
+		exists, err := s.uploader.Exists(davFilePath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			log.Printf("File %s already downloaded!", filename)
+			return nil
+		}
+
+		return s.downloadAndUpload(u, filename, davFilePath)
+	}
 
-	s.c.OnHTML("div[data-zest] a[href]", func(a *colly.HTMLElement) {
-		href := a.Attr("href")
-		content := strings.ToLower(a.Text)
+	manifest, err := s.getManifest(store)
+	if err != nil {
+		return err
+	}
 
-		if !strings.Contains(href, "ctfassets.net") || !strings.Contains(content, "recette") {
-			return
+	if entry := manifest.BySourceURL(u); entry != nil {
+		unchanged, err := s.sourceUnchanged(u, entry)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			log.Printf("File %s unchanged since last run, skipping", filename)
+			return nil
 		}
+	}
 
-		url = append(url, href)
+	return s.downloadAndDedup(u, filename, davFilePath, manifest, store)
+}
+
+// getManifest loads manifest.json at most once per Scrapper and caches it,
+// so concurrent Download calls from DownloadAll share (and safely mutate,
+// via Manifest's own locking) a single in-memory view instead of racing to
+// read-modify-write the file underneath each other.
+func (s *Scrapper) getManifest(store ContentAddressableStore) (*Manifest, error) {
+	s.manifestOnce.Do(func() {
+		s.manifest, s.manifestErr = loadManifest(store, path.Join(s.davFolder, "manifest.json"))
 	})
 
-	s.c.Visit(s.url)
+	return s.manifest, s.manifestErr
+}
+
+// DownloadAll fans results out across a bounded pool of goroutines,
+// rate-limited per host, retrying transient failures with exponential
+// backoff. It returns a *MultiError listing every URL that still failed
+// after retries, or nil if every download succeeded.
+func (s *Scrapper) DownloadAll(results []ScrapeResult) error {
+	limiter := newPerHostRateLimiter(s.ratePerHost)
+	sem := make(chan struct{}, s.parallelism)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var multiErr MultiError
+
+	for _, r := range results {
+		r := r
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			limiter.Wait(hostOf(r.URL))
+
+			if err := s.downloadWithRetry(r.SourceName, r.URL, r.SuggestedFilename); err != nil {
+				mu.Lock()
+				multiErr.Add(r.URL, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(multiErr.Errors) > 0 {
+		return &multiErr
+	}
+
+	return nil
+}
+
+// downloadWithRetry retries Download on transient errors (5xx responses,
+// network errors) with exponential backoff, up to MaxDownloadAttempts.
+func (s *Scrapper) downloadWithRetry(sourceName, u, filename string) error {
+	var err error
+	backoff := time.Second
+
+	for attempt := 1; attempt <= MaxDownloadAttempts; attempt++ {
+		err = s.Download(sourceName, u, filename)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		if attempt < MaxDownloadAttempts {
+			log.Printf("Retrying %s after error: %s", filename, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return err
+}
+
+// isRetryable reports whether err is transient and worth another attempt:
+// a 5xx from the source GET (*httpStatusError), the WebDAV
+// PUT/MKCOL/MOVE calls made while storing the file (*davStatusError), the
+// TUS create/patch/head calls (*tusStatusError), or a network-level error.
+func isRetryable(err error) bool {
+	var statusErr statusCoder
+	if errors.As(err, &statusErr) {
+		return statusErr.HTTPStatusCode() >= 500
+	}
 
-	return url
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
-func (s *Scrapper) Download(u, filename string) error {
-	davFolder := path.Join(s.davFolder, time.Now().Format(s.davFolderFormat))
-	err := s.dav.MkdirAll(davFolder, 0755)
+// sourceUnchanged reports whether u still points at the same content as
+// entry, using a HEAD request so the (possibly large) file itself isn't
+// re-fetched just to find out.
+func (s *Scrapper) sourceUnchanged(u string, entry *ManifestEntry) (bool, error) {
+	resp, err := s.client.Head(u)
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer resp.Body.Close()
 
-	davFilePath := path.Join(davFolder, filename)
-	_, err = s.dav.Stat(davFilePath)
-	if err == nil {
-		log.Printf("File %s already downloaded!", filename)
-		return nil
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
 	}
 
+	if etag := resp.Header.Get("ETag"); etag != "" && entry.ETag != "" {
+		return etag == entry.ETag, nil
+	}
+
+	lastModified := resp.Header.Get("Last-Modified")
+	return lastModified != "" && lastModified == entry.LastModified && resp.ContentLength == entry.ContentLength, nil
+}
+
+// downloadAndUpload is the plain, non-dedup download path used for
+// Uploaders that don't implement ContentAddressableStore (this is the path
+// TUSUploader takes). It always fetches u in full: resuming an interrupted
+// TUSUploader upload only skips re-sending bytes to the TUS endpoint that
+// it already acknowledged, not re-fetching them from the source.
+func (s *Scrapper) downloadAndUpload(u, filename, davFilePath string) error {
 	resp, err := s.client.Get(u)
 	if err != nil {
 		return err
@@ -99,34 +279,10 @@ func (s *Scrapper) Download(u, filename string) error {
 	defer body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Wrong status code: %d", resp.StatusCode)
-	}
-
-	// HEADS UP !
-	//
-	// Because of a potential bug with the default Nextcloud configuration,
-	// the whole file is loaded in memory before being sent over the network.
-	//
-	// Long explanation:
-	//
-	// The golang net/http library behaves differently depending on the
-	// implementation behind the io.Reader interface.
-	//
-	// * bytes.Reader, strings.Reader and bytes.Buffer: Content-Length is set
-	//   to the size of the content.
-	//
-	// * others: no content-length is set and therefore chunked encoding is used.
-	//
-	// It looks like the default Nginx configuration for Nextcloud does not like
-	// chunked encoding...
-	//
-	// See https://github.com/photoprism/photoprism/issues/443#issuecomment-685608490
-	// and https://github.com/studio-b12/gowebdav/issues/35
-	content, err := ioutil.ReadAll(body)
-	reader := bytes.NewReader(content)
-
-	err = s.dav.WriteStream(davFilePath, reader, 0644)
-	if err != nil {
+		return &httpStatusError{URL: u, Code: resp.StatusCode}
+	}
+
+	if err := s.uploader.Upload(u, body, resp.ContentLength, davFilePath); err != nil {
 		return err
 	}
 
@@ -135,6 +291,64 @@ func (s *Scrapper) Download(u, filename string) error {
 	return nil
 }
 
+// downloadAndDedup downloads u to a local temp file while computing its
+// SHA-256, then either issues a server-side Copy from an existing manifest
+// entry with the same hash or uploads the freshly downloaded bytes,
+// recording the result back into the manifest.
+func (s *Scrapper) downloadAndDedup(u, filename, davFilePath string, manifest *Manifest, store ContentAddressableStore) error {
+	resp, err := s.client.Get(u)
+	if err != nil {
+		return err
+	}
+
+	body := resp.Body
+	defer body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &httpStatusError{URL: u, Code: resp.StatusCode}
+	}
+
+	tmp, err := ioutil.TempFile("", "recette-*.pdf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), body)
+	if err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	if existing := manifest.BySHA256(sum); existing != nil && existing.StoredPath != davFilePath {
+		if err := store.Copy(existing.StoredPath, davFilePath); err != nil {
+			return err
+		}
+		log.Printf("Deduplicated %s from %s (identical content)", filename, existing.StoredPath)
+	} else {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.uploader.Upload(u, tmp, size, davFilePath); err != nil {
+			return err
+		}
+		log.Printf("Downloaded %s", filename)
+	}
+
+	manifest.Add(ManifestEntry{
+		SourceURL:     u,
+		SHA256:        sum,
+		StoredPath:    davFilePath,
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+		ContentLength: size,
+	})
+
+	return manifest.save()
+}
+
 func initConfig() {
 	if len(os.Args) != 2 {
 		fmt.Printf("Usage: %s config.yaml\n", os.Args[0])
@@ -155,52 +369,68 @@ func initConfig() {
 		os.Exit(1)
 	}
 
-	for _, config := range []string{"Scrapper.URL", "WebDAV.URL", "WebDAV.Username", "WebDAV.Password", "WebDAV.Folder", "WebDAV.FolderFormat"} {
+	viper.SetDefault("Uploader.Type", "webdav")
+
+	requiredConfig := []string{"WebDAV.Folder", "WebDAV.FolderFormat"}
+	if !viper.IsSet("Sources") {
+		requiredConfig = append(requiredConfig, "Scrapper.URL")
+	}
+	switch viper.GetString("Uploader.Type") {
+	case "tus":
+		requiredConfig = append(requiredConfig, "Uploader.Tus.Endpoint")
+	default:
+		requiredConfig = append(requiredConfig, "WebDAV.URL", "WebDAV.Username", "WebDAV.Password")
+	}
+
+	for _, config := range requiredConfig {
 		if viper.GetString(config) == "" {
 			fmt.Printf("key %s is missing from configuration file\n", config)
 			os.Exit(1)
 		}
 	}
 	viper.SetDefault("Scrapper.Timeout", 60*time.Second)
+	viper.SetDefault("Scrapper.ChunkSize", DefaultChunkSize)
+	viper.SetDefault("Scrapper.Parallelism", DefaultParallelism)
+	viper.SetDefault("Scrapper.RatePerHost", DefaultRatePerHost)
+	viper.SetDefault("Uploader.Tus.StateFile", "tus-state.json")
 }
 
 func main() {
 	initConfig()
 
+	var sources []SourceConfig
+	if err := viper.UnmarshalKey("Sources", &sources); err != nil {
+		log.Fatal(err)
+	}
+
 	scrapper, err := NewScrapper(ScrapperConfig{
 		ScrapperUrl:     viper.GetString("Scrapper.URL"),
+		Sources:         sources,
 		DavUrl:          viper.GetString("WebDAV.URL"),
 		DavUsername:     viper.GetString("WebDAV.Username"),
 		DavPassword:     viper.GetString("WebDAV.Password"),
 		DavFolder:       viper.GetString("WebDAV.Folder"),
 		DavFolderFormat: viper.GetString("WebDAV.FolderFormat"),
 		HttpTimeout:     viper.GetDuration("Scrapper.Timeout"),
+		ChunkSize:       viper.GetInt64("Scrapper.ChunkSize"),
+		UploaderType:    viper.GetString("Uploader.Type"),
+		TusEndpoint:     viper.GetString("Uploader.Tus.Endpoint"),
+		TusStateFile:    viper.GetString("Uploader.Tus.StateFile"),
+		Parallelism:     viper.GetInt("Scrapper.Parallelism"),
+		RatePerHost:     viper.GetFloat64("Scrapper.RatePerHost"),
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	urls := scrapper.Scrape()
-
-	fail := false
-	atLeastOne := false
-	for _, u := range urls {
-		parts, err := url.Parse(u)
-		if err != nil {
-			log.Printf("Cannot parse URL '%s': %s", u, err)
-			continue
-		}
-		parts.Scheme = "https" // scheme is missing
-		filename := path.Base(parts.Path)
-		err = scrapper.Download(parts.String(), filename)
-		if err != nil {
-			fail = true
-			log.Printf("Cannot download file '%s': %s", filename, err)
-		}
-		atLeastOne = true
+	results := scrapper.Scrape()
+	if len(results) == 0 {
+		log.Print("No recipe found")
+		os.Exit(1)
 	}
 
-	if fail || !atLeastOne {
+	if err := scrapper.DownloadAll(results); err != nil {
+		log.Print(err)
 		os.Exit(1)
 	}
 
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type fakeNetError struct{}
+
+func (fakeNetError) Error() string   { return "network unreachable" }
+func (fakeNetError) Timeout() bool   { return false }
+func (fakeNetError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"source 5xx", &httpStatusError{URL: "https://example.com/a.pdf", Code: 503}, true},
+		{"source 4xx", &httpStatusError{URL: "https://example.com/a.pdf", Code: 404}, false},
+		{"webdav 5xx", &davStatusError{StatusCode: 502, Status: "502 Bad Gateway"}, true},
+		{"webdav 4xx", &davStatusError{StatusCode: 423, Status: "423 Locked"}, false},
+		{"network error", fakeNetError{}, true},
+		{"wrapped source 5xx", fmt.Errorf("download failed: %w", &httpStatusError{Code: 500}), true},
+		{"plain error", errors.New("unexpected"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
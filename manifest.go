@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// ContentAddressableStore is implemented by Uploaders that can host the
+// dedup manifest and copy previously-stored content to a new destination
+// without re-transferring bytes. Uploaders that don't support it (e.g.
+// TUSUploader) fall back to the plain Uploader.Exists check in
+// Scrapper.Download.
+type ContentAddressableStore interface {
+	ReadManifest(manifestPath string) ([]byte, error)
+	WriteManifest(manifestPath string, data []byte) error
+	Copy(srcPath, destPath string) error
+}
+
+// ManifestEntry records where a downloaded recipe PDF ended up being
+// stored, along with enough metadata about its source to detect unchanged
+// remote files across runs without re-downloading them.
+type ManifestEntry struct {
+	SourceURL     string `json:"sourceURL"`
+	SHA256        string `json:"sha256"`
+	StoredPath    string `json:"storedPath"`
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"lastModified,omitempty"`
+	ContentLength int64  `json:"contentLength,omitempty"`
+}
+
+// Manifest is the in-memory view of manifest.json, a JSON file kept at the
+// root of davFolder mapping {sourceURL, sha256} to storedPath so that
+// already-fetched recipes are recognized even when HelloFresh republishes
+// them under a new filename.
+type Manifest struct {
+	path    string
+	store   ContentAddressableStore
+	mu      sync.Mutex
+	entries []ManifestEntry
+}
+
+func loadManifest(store ContentAddressableStore, manifestPath string) (*Manifest, error) {
+	data, err := store.ReadManifest(manifestPath)
+	if os.IsNotExist(err) {
+		return &Manifest{path: manifestPath, store: store}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{path: manifestPath, store: store}
+	if err := json.Unmarshal(data, &m.entries); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return m.store.WriteManifest(m.path, data)
+}
+
+// BySourceURL returns the most recent entry recorded for sourceURL, or nil
+// if it has never been downloaded.
+func (m *Manifest) BySourceURL(sourceURL string) *ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found *ManifestEntry
+	for i := range m.entries {
+		if m.entries[i].SourceURL == sourceURL {
+			entry := m.entries[i]
+			found = &entry
+		}
+	}
+
+	return found
+}
+
+// BySHA256 returns an existing entry whose content matches sum, regardless
+// of which source URL produced it, so its StoredPath can be used as the
+// source of a server-side copy.
+func (m *Manifest) BySHA256(sum string) *ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.entries {
+		if m.entries[i].SHA256 == sum {
+			entry := m.entries[i]
+			return &entry
+		}
+	}
+
+	return nil
+}
+
+func (m *Manifest) Add(entry ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = append(m.entries, entry)
+}
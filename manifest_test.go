@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestManifestBySourceURL(t *testing.T) {
+	m := &Manifest{}
+
+	if got := m.BySourceURL("https://example.com/a.pdf"); got != nil {
+		t.Fatalf("BySourceURL on empty manifest = %+v, want nil", got)
+	}
+
+	m.Add(ManifestEntry{SourceURL: "https://example.com/a.pdf", SHA256: "sha-1", StoredPath: "/a.pdf"})
+	m.Add(ManifestEntry{SourceURL: "https://example.com/b.pdf", SHA256: "sha-2", StoredPath: "/b.pdf"})
+	// A later entry for the same source URL (republished under the same
+	// URL with different content) must shadow the earlier one.
+	m.Add(ManifestEntry{SourceURL: "https://example.com/a.pdf", SHA256: "sha-3", StoredPath: "/a-2.pdf"})
+
+	got := m.BySourceURL("https://example.com/a.pdf")
+	if got == nil || got.SHA256 != "sha-3" {
+		t.Fatalf("BySourceURL = %+v, want most recent entry with SHA256 sha-3", got)
+	}
+
+	if got := m.BySourceURL("https://example.com/missing.pdf"); got != nil {
+		t.Fatalf("BySourceURL for unknown URL = %+v, want nil", got)
+	}
+}
+
+func TestManifestBySHA256(t *testing.T) {
+	m := &Manifest{}
+	m.Add(ManifestEntry{SourceURL: "https://example.com/a.pdf", SHA256: "sha-1", StoredPath: "/a.pdf"})
+	m.Add(ManifestEntry{SourceURL: "https://example.com/a-renamed.pdf", SHA256: "sha-1", StoredPath: "/a.pdf"})
+
+	got := m.BySHA256("sha-1")
+	if got == nil || got.StoredPath != "/a.pdf" {
+		t.Fatalf("BySHA256 = %+v, want entry with StoredPath /a.pdf", got)
+	}
+
+	if got := m.BySHA256("sha-unknown"); got != nil {
+		t.Fatalf("BySHA256 for unknown sum = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "fmt"
+
+// statusCoder is implemented by errors that carry an HTTP status code —
+// httpStatusError (source GET), davStatusError (WebDAV PUT/MKCOL/
+// MOVE) and tusStatusError (TUS create/patch/head) — so isRetryable and
+// isUploadGone can check the status once regardless of which leg of an
+// upload produced the error.
+type statusCoder interface {
+	HTTPStatusCode() int
+}
+
+// httpStatusError carries the HTTP status code of an unexpected response to
+// a source GET, so retry logic can tell a transient 5xx apart from a
+// permanent 4xx.
+type httpStatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("wrong status code for %s: %d", e.URL, e.Code)
+}
+
+func (e *httpStatusError) HTTPStatusCode() int {
+	return e.Code
+}
+
+// DownloadError pairs a failed source URL with the error encountered
+// downloading or storing it.
+type DownloadError struct {
+	URL string
+	Err error
+}
+
+// MultiError collects the per-URL errors from a DownloadAll run.
+type MultiError struct {
+	Errors []DownloadError
+}
+
+func (m *MultiError) Add(url string, err error) {
+	m.Errors = append(m.Errors, DownloadError{URL: url, Err: err})
+}
+
+func (m *MultiError) Error() string {
+	msg := fmt.Sprintf("%d download(s) failed:", len(m.Errors))
+	for _, e := range m.Errors {
+		msg += fmt.Sprintf("\n  %s: %s", e.URL, e.Err)
+	}
+
+	return msg
+}
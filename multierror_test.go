@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMultiErrorError(t *testing.T) {
+	var m MultiError
+	m.Add("https://example.com/a.pdf", errors.New("boom"))
+	m.Add("https://example.com/b.pdf", errors.New("kaboom"))
+
+	got := m.Error()
+	for _, want := range []string{"2 download(s) failed", "https://example.com/a.pdf: boom", "https://example.com/b.pdf: kaboom"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("MultiError.Error() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	err := &httpStatusError{URL: "https://example.com/a.pdf", Code: 503}
+	if err.HTTPStatusCode() != 503 {
+		t.Errorf("HTTPStatusCode() = %d, want 503", err.HTTPStatusCode())
+	}
+}
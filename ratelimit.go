@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultRatePerHost is the number of requests per second allowed against a
+// single host when ScrapperConfig.RatePerHost is left unset.
+const DefaultRatePerHost = 2.0
+
+// perHostRateLimiter hands out a token-bucket limiter per host, so
+// concurrent downloads don't hammer a single origin (e.g. ctfassets.net)
+// hard enough to trip its WAF, while still letting different hosts proceed
+// at full speed.
+type perHostRateLimiter struct {
+	ratePerSecond float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newPerHostRateLimiter(ratePerSecond float64) *perHostRateLimiter {
+	if ratePerSecond <= 0 {
+		ratePerSecond = DefaultRatePerHost
+	}
+
+	return &perHostRateLimiter{
+		ratePerSecond: ratePerSecond,
+		buckets:       map[string]*tokenBucket{},
+	}
+}
+
+// Wait blocks until a request to host is allowed to proceed.
+func (l *perHostRateLimiter) Wait(host string) {
+	l.mu.Lock()
+	bucket, ok := l.buckets[host]
+	if !ok {
+		bucket = newTokenBucket(l.ratePerSecond)
+		l.buckets[host] = bucket
+	}
+	l.mu.Unlock()
+
+	bucket.take()
+}
+
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	return parsed.Host
+}
+
+// tokenBucket is a small token-bucket rate limiter: it refills at
+// ratePerSecond tokens/second, up to a capacity of one second's worth of
+// tokens (but never less than one token), and blocks callers until a token
+// is available.
+type tokenBucket struct {
+	ratePerSecond float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(ratePerSecond float64) *tokenBucket {
+	return &tokenBucket{
+		ratePerSecond: ratePerSecond,
+		tokens:        ratePerSecond,
+		last:          time.Now(),
+	}
+}
+
+func (b *tokenBucket) take() {
+	for {
+		b.mu.Lock()
+
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.ratePerSecond
+		if capacity := math.Max(b.ratePerSecond, 1); b.tokens > capacity {
+			b.tokens = capacity
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.ratePerSecond * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsBurstUpToCapacity(t *testing.T) {
+	b := newTokenBucket(10) // capacity: 10 tokens
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		b.take()
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("draining a full bucket took %s, want near-instant", elapsed)
+	}
+}
+
+func TestTokenBucketBlocksOnceDrained(t *testing.T) {
+	b := newTokenBucket(10) // 10 tokens/sec, so a refill takes ~100ms/token
+	for i := 0; i < 10; i++ {
+		b.take()
+	}
+
+	start := time.Now()
+	b.take()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("take() on a drained bucket returned after %s, want it to block for a refill", elapsed)
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{"https://images.ctfassets.net/foo/bar.pdf", "images.ctfassets.net"},
+		{"http://example.com:8080/path", "example.com:8080"},
+		{"http://[::1", "http://[::1"},
+	}
+
+	for _, tt := range tests {
+		if got := hostOf(tt.rawURL); got != tt.want {
+			t.Errorf("hostOf(%q) = %q, want %q", tt.rawURL, got, tt.want)
+		}
+	}
+}
+
+func TestPerHostRateLimiterIsolatesHosts(t *testing.T) {
+	l := newPerHostRateLimiter(10)
+
+	for i := 0; i < 10; i++ {
+		l.Wait("a.example.com")
+	}
+
+	// b.example.com has its own bucket and should not be slowed down by
+	// a.example.com having just exhausted its tokens.
+	start := time.Now()
+	l.Wait("b.example.com")
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("Wait on an untouched host took %s, want near-instant", elapsed)
+	}
+}
+
+func TestNewPerHostRateLimiterDefaultsRate(t *testing.T) {
+	l := newPerHostRateLimiter(0)
+	if l.ratePerSecond != DefaultRatePerHost {
+		t.Fatalf("ratePerSecond = %v, want default %v", l.ratePerSecond, DefaultRatePerHost)
+	}
+}
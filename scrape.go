@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gocolly/colly"
+)
+
+// LoginConfig describes a form-based login step to run before a source is
+// scraped, for sites that gate their recipe listing behind authentication.
+type LoginConfig struct {
+	FormURL string
+	Fields  map[string]string
+}
+
+// SourceConfig describes one site to mirror recipes from: where to start,
+// how to recognize recipe links on the page, and how to follow pagination.
+type SourceConfig struct {
+	Name               string
+	StartURL           string
+	LinkSelector       string
+	URLFilter          string
+	URLFilterRegex     bool
+	TextFilter         string
+	TextFilterRegex    bool
+	PaginationSelector string
+	Login              *LoginConfig
+}
+
+// ScrapeResult is one recipe link found while scraping a source, resolved
+// to an absolute URL with a filename suggested from its path.
+type ScrapeResult struct {
+	SourceName        string
+	URL               string
+	SuggestedFilename string
+}
+
+// defaultHelloFreshSource reproduces the historical hardcoded scraping rule
+// (div[data-zest] a[href], filtered to ctfassets.net links whose text
+// mentions "recette") as a SourceConfig, for configs that only set the
+// legacy Scrapper.URL key.
+func defaultHelloFreshSource(startURL string) SourceConfig {
+	return SourceConfig{
+		Name:         "hellofresh",
+		StartURL:     startURL,
+		LinkSelector: "div[data-zest] a[href]",
+		URLFilter:    "ctfassets.net",
+		TextFilter:   "recette",
+	}
+}
+
+// AddSource registers a source to be visited by a subsequent call to
+// Scrape. The source's URL/text filters are validated (a malformed
+// regex returns an error here rather than panicking during Scrape).
+func (s *Scrapper) AddSource(source SourceConfig) error {
+	if err := validateSource(source); err != nil {
+		return fmt.Errorf("source %q: %w", source.Name, err)
+	}
+
+	s.sources = append(s.sources, source)
+	return nil
+}
+
+// validateSource checks that a source's filters are well-formed, without
+// keeping the compiled matchers around: scrapeSource recompiles them per
+// scrape, which is cheap and keeps matcher itself infallible to call there.
+func validateSource(source SourceConfig) error {
+	if _, err := matcher(source.URLFilter, source.URLFilterRegex); err != nil {
+		return fmt.Errorf("invalid URLFilter: %w", err)
+	}
+	if _, err := matcher(source.TextFilter, source.TextFilterRegex); err != nil {
+		return fmt.Errorf("invalid TextFilter: %w", err)
+	}
+	return nil
+}
+
+// Scrape visits every registered source and returns the recipe links found
+// across all of them.
+func (s *Scrapper) Scrape() []ScrapeResult {
+	var results []ScrapeResult
+
+	for _, source := range s.sources {
+		results = append(results, s.scrapeSource(source)...)
+	}
+
+	return results
+}
+
+func (s *Scrapper) scrapeSource(source SourceConfig) []ScrapeResult {
+	var results []ScrapeResult
+
+	c := colly.NewCollector()
+
+	if source.Login != nil {
+		if err := c.Post(source.Login.FormURL, source.Login.Fields); err != nil {
+			log.Printf("Cannot log in for source %s: %s", source.Name, err)
+			return nil
+		}
+	}
+
+	// Sources are validated in AddSource, so these filters are already
+	// known to compile.
+	matchesURL, _ := matcher(source.URLFilter, source.URLFilterRegex)
+	matchesText, _ := matcher(source.TextFilter, source.TextFilterRegex)
+
+	c.OnHTML(source.LinkSelector, func(a *colly.HTMLElement) {
+		href := a.Attr("href")
+		text := strings.ToLower(a.Text)
+
+		if !matchesURL(href) || !matchesText(text) {
+			return
+		}
+
+		resolved, filename, err := resolveLink(href)
+		if err != nil {
+			log.Printf("Cannot parse URL '%s': %s", href, err)
+			return
+		}
+
+		results = append(results, ScrapeResult{
+			SourceName:        source.Name,
+			URL:               resolved,
+			SuggestedFilename: filename,
+		})
+	})
+
+	if source.PaginationSelector != "" {
+		c.OnHTML(source.PaginationSelector, func(e *colly.HTMLElement) {
+			if next := e.Attr("href"); next != "" {
+				e.Request.Visit(next)
+			}
+		})
+	}
+
+	c.Visit(source.StartURL)
+
+	return results
+}
+
+// matcher builds a predicate from a source's URL/text filter: a plain
+// substring match, a regular expression, or an always-true match when no
+// filter is configured. It returns an error rather than panicking when
+// pattern is an invalid regular expression, so a malformed source in the
+// config file can be rejected cleanly instead of crashing at scrape time.
+func matcher(pattern string, isRegex bool) (func(string) bool, error) {
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+
+	if isRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	return func(s string) bool { return strings.Contains(s, pattern) }, nil
+}
+
+// resolveLink turns a (possibly scheme-less) href into an absolute URL and
+// suggests a filename from its path.
+func resolveLink(href string) (string, string, error) {
+	parts, err := url.Parse(href)
+	if err != nil {
+		return "", "", err
+	}
+	if parts.Scheme == "" {
+		parts.Scheme = "https" // scheme is missing
+	}
+
+	return parts.String(), path.Base(parts.Path), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		isRegex bool
+		input   string
+		want    bool
+	}{
+		{"empty pattern always matches", "", false, "anything", true},
+		{"substring match", "ctfassets.net", false, "https://images.ctfassets.net/a.pdf", true},
+		{"substring no match", "ctfassets.net", false, "https://example.com/a.pdf", false},
+		{"regex match", `recette-\d+`, true, "recette-42", true},
+		{"regex no match", `recette-\d+`, true, "recette-x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := matcher(tt.pattern, tt.isRegex)
+			if err != nil {
+				t.Fatalf("matcher(%q, %v) returned error: %s", tt.pattern, tt.isRegex, err)
+			}
+			if got := m(tt.input); got != tt.want {
+				t.Errorf("matcher(%q, %v)(%q) = %v, want %v", tt.pattern, tt.isRegex, tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherInvalidRegex(t *testing.T) {
+	if _, err := matcher("(unclosed", true); err == nil {
+		t.Fatal("matcher with malformed regex returned no error")
+	}
+}
+
+func TestValidateSourceRejectsInvalidFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		source SourceConfig
+	}{
+		{"invalid URLFilter regex", SourceConfig{Name: "s", URLFilter: "(unclosed", URLFilterRegex: true}},
+		{"invalid TextFilter regex", SourceConfig{Name: "s", TextFilter: "(unclosed", TextFilterRegex: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSource(tt.source); err == nil {
+				t.Fatal("validateSource returned no error for a malformed filter")
+			}
+		})
+	}
+}
+
+func TestAddSourceRejectsInvalidFilters(t *testing.T) {
+	s := &Scrapper{}
+	err := s.AddSource(SourceConfig{Name: "broken", URLFilter: "(unclosed", URLFilterRegex: true})
+	if err == nil {
+		t.Fatal("AddSource returned no error for a malformed filter")
+	}
+	if len(s.sources) != 0 {
+		t.Fatalf("AddSource registered a source despite returning an error: %+v", s.sources)
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	tests := []struct {
+		href         string
+		wantURL      string
+		wantFilename string
+	}{
+		{"https://images.ctfassets.net/x/y/recette.pdf", "https://images.ctfassets.net/x/y/recette.pdf", "recette.pdf"},
+		{"//images.ctfassets.net/x/recette.pdf", "https://images.ctfassets.net/x/recette.pdf", "recette.pdf"},
+	}
+
+	for _, tt := range tests {
+		gotURL, gotFilename, err := resolveLink(tt.href)
+		if err != nil {
+			t.Fatalf("resolveLink(%q) returned error: %s", tt.href, err)
+		}
+		if gotURL != tt.wantURL {
+			t.Errorf("resolveLink(%q) URL = %q, want %q", tt.href, gotURL, tt.wantURL)
+		}
+		if gotFilename != tt.wantFilename {
+			t.Errorf("resolveLink(%q) filename = %q, want %q", tt.href, gotFilename, tt.wantFilename)
+		}
+	}
+}
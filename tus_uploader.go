@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tusResumableVersion = "1.0.0"
+
+// TUSUploader is an alternative Uploader implementation that speaks the TUS
+// 1.0 resumable upload protocol against a configurable creation endpoint.
+// In-progress upload URLs are persisted to a local state file keyed by
+// source URL, so an interrupted run resumes the same TUS upload (skipping
+// chunks the server already acknowledged) instead of starting a new one.
+//
+// This only saves re-uploading bytes to the TUS endpoint: the source file
+// is still fetched in full on every invocation (see Scrapper.downloadAndUpload),
+// and Upload discards the already-acknowledged prefix after the fact. A
+// range request against the source would be needed to also avoid
+// re-downloading it.
+type TUSUploader struct {
+	endpoint  string
+	client    *http.Client
+	chunkSize int64
+	stateFile string
+
+	mu      sync.Mutex
+	uploads map[string]string // sourceURL -> upload URL
+}
+
+func NewTUSUploader(endpoint string, timeout time.Duration, chunkSize int64, stateFile string) (*TUSUploader, error) {
+	u := &TUSUploader{
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		chunkSize: chunkSize,
+		stateFile: stateFile,
+		uploads:   map[string]string{},
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+
+	if err := u.loadState(); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (t *TUSUploader) loadState() error {
+	data, err := ioutil.ReadFile(t.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &t.uploads)
+}
+
+func (t *TUSUploader) saveState() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.MarshalIndent(t.uploads, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(t.stateFile, data, 0644)
+}
+
+// EnsureFolder is a no-op: TUS endpoints have no folder hierarchy, the
+// destination path is only carried as upload metadata.
+func (t *TUSUploader) EnsureFolder(folder string) error {
+	return nil
+}
+
+// Exists always reports false: a TUS endpoint cannot be queried for an
+// already-uploaded file by path, so dedup is left to the resumable state
+// file instead.
+func (t *TUSUploader) Exists(destPath string) (bool, error) {
+	return false, nil
+}
+
+func (t *TUSUploader) Upload(sourceURL string, content io.Reader, size int64, destPath string) error {
+	uploadURL, offset, err := t.resume(sourceURL)
+	if err != nil {
+		return err
+	}
+
+	if uploadURL == "" {
+		uploadURL, err = t.create(destPath, size)
+		if err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		t.uploads[sourceURL] = uploadURL
+		t.mu.Unlock()
+		if err := t.saveState(); err != nil {
+			return err
+		}
+	} else if offset > 0 {
+		log.Printf("Resuming TUS upload of %s at offset %d", destPath, offset)
+		if _, err := io.CopyN(ioutil.Discard, content, offset); err != nil {
+			return fmt.Errorf("failed to skip to resume offset %d: %w", offset, err)
+		}
+	}
+
+	if err := t.sendChunks(content, size, offset, uploadURL); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	delete(t.uploads, sourceURL)
+	t.mu.Unlock()
+
+	return t.saveState()
+}
+
+// sendChunks PATCHes content to uploadURL starting at offset, advancing by
+// whatever offset the server reports after each chunk. On a PATCH error, it
+// HEADs the upload URL to recover the server-side offset and, if that offset
+// lies within the chunk just sent, resends the part of the chunk the server
+// has not acknowledged, once, before giving up.
+func (t *TUSUploader) sendChunks(content io.Reader, size, offset int64, uploadURL string) error {
+	buf := make([]byte, t.chunkSize)
+	for offset < size {
+		want := t.chunkSize
+		if remaining := size - offset; remaining < want {
+			want = remaining
+		}
+
+		n, err := io.ReadFull(content, buf[:want])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		chunk := buf[:n]
+
+		newOffset, perr := t.patch(uploadURL, offset, chunk)
+		if perr != nil {
+			recovered, herr := t.head(uploadURL)
+			if herr != nil || recovered < offset || recovered > offset+int64(n) {
+				return perr
+			}
+			newOffset = recovered
+			if recovered < offset+int64(n) {
+				newOffset, perr = t.patch(uploadURL, recovered, chunk[recovered-offset:])
+				if perr != nil {
+					return perr
+				}
+			}
+		}
+
+		offset = newOffset
+	}
+
+	return nil
+}
+
+func (t *TUSUploader) create(destPath string, size int64) (string, error) {
+	filename := path.Base(destPath)
+	metadata := fmt.Sprintf("filename %s,filetype %s",
+		base64.StdEncoding.EncodeToString([]byte(filename)),
+		base64.StdEncoding.EncodeToString([]byte("application/pdf")))
+
+	req, err := http.NewRequest("POST", t.endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", metadata)
+	req.Header.Set("Tus-Resumable", tusResumableVersion)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", &tusStatusError{URL: t.endpoint, Code: resp.StatusCode}
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("tus creation response is missing a Location header")
+	}
+
+	return t.resolve(location)
+}
+
+func (t *TUSUploader) patch(uploadURL string, offset int64, chunk []byte) (int64, error) {
+	req, err := http.NewRequest("PATCH", uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Tus-Resumable", tusResumableVersion)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, &tusStatusError{URL: uploadURL, Code: resp.StatusCode}
+	}
+
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+func (t *TUSUploader) head(uploadURL string) (int64, error) {
+	req, err := http.NewRequest("HEAD", uploadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusResumableVersion)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, &tusStatusError{URL: uploadURL, Code: resp.StatusCode}
+	}
+
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// tusStatusError carries the HTTP status code of an unexpected response to
+// a TUS create/patch/head request, so resume can tell a 404/410 (the
+// upload is actually gone) apart from a transient 5xx or network error.
+type tusStatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *tusStatusError) Error() string {
+	return fmt.Sprintf("tus request to %s failed: %d", e.URL, e.Code)
+}
+
+func (e *tusStatusError) HTTPStatusCode() int {
+	return e.Code
+}
+
+// resume looks up a previously persisted upload URL for sourceURL and, if
+// found, HEADs it to recover the server-side offset. The persisted mapping
+// is only dropped when the server confirms the upload itself is gone (404
+// or 410); a transient/network error on the HEAD is propagated instead, so
+// a flaky HEAD doesn't discard an otherwise-resumable upload.
+func (t *TUSUploader) resume(sourceURL string) (string, int64, error) {
+	t.mu.Lock()
+	uploadURL, ok := t.uploads[sourceURL]
+	t.mu.Unlock()
+	if !ok {
+		return "", 0, nil
+	}
+
+	offset, err := t.head(uploadURL)
+	if err != nil {
+		if !isUploadGone(err) {
+			return "", 0, err
+		}
+
+		t.mu.Lock()
+		delete(t.uploads, sourceURL)
+		t.mu.Unlock()
+		return "", 0, t.saveState()
+	}
+
+	return uploadURL, offset, nil
+}
+
+// isUploadGone reports whether err is a 404 or 410 response to a TUS
+// request, meaning the server has no record of the upload rather than the
+// request having merely failed to reach it.
+func isUploadGone(err error) bool {
+	var statusErr statusCoder
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	code := statusErr.HTTPStatusCode()
+	return code == http.StatusNotFound || code == http.StatusGone
+}
+
+func (t *TUSUploader) resolve(location string) (string, error) {
+	base, err := url.Parse(t.endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
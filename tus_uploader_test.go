@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeTUSServer is a minimal in-memory implementation of the bits of the
+// TUS 1.0 protocol TUSUploader relies on: POST to create an upload, PATCH
+// to append bytes at an offset, HEAD to report the current offset.
+type fakeTUSServer struct {
+	mu   sync.Mutex
+	next int
+	data map[string][]byte
+	// headStatus, when set, overrides the status code HEAD responds
+	// with instead of looking up the upload's current offset.
+	headStatus int
+}
+
+func newFakeTUSServer() *fakeTUSServer {
+	return &fakeTUSServer{data: map[string][]byte{}}
+}
+
+func (f *fakeTUSServer) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			f.mu.Lock()
+			f.next++
+			id := strconv.Itoa(f.next)
+			f.data[id] = nil
+			f.mu.Unlock()
+
+			w.Header().Set("Location", "/"+id)
+			w.WriteHeader(http.StatusCreated)
+
+		case "PATCH":
+			id := r.URL.Path[1:]
+			offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil {
+				http.Error(w, "bad offset", http.StatusBadRequest)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			f.mu.Lock()
+			current := f.data[id]
+			if offset != int64(len(current)) {
+				f.mu.Unlock()
+				http.Error(w, fmt.Sprintf("offset mismatch: got %d, have %d", offset, len(current)), http.StatusConflict)
+				return
+			}
+			f.data[id] = append(current, body...)
+			newOffset := len(f.data[id])
+			f.mu.Unlock()
+
+			w.Header().Set("Upload-Offset", strconv.Itoa(newOffset))
+			w.WriteHeader(http.StatusNoContent)
+
+		case "HEAD":
+			id := r.URL.Path[1:]
+			f.mu.Lock()
+			status := f.headStatus
+			offset := len(f.data[id])
+			f.mu.Unlock()
+
+			if status != 0 {
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.Itoa(offset))
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (f *fakeTUSServer) stored(id string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]byte(nil), f.data[id]...)
+}
+
+func newTestTUSUploader(t *testing.T, endpoint string) *TUSUploader {
+	t.Helper()
+	u, err := NewTUSUploader(endpoint, 0, 4, t.TempDir()+"/state.json")
+	if err != nil {
+		t.Fatalf("NewTUSUploader() error = %v", err)
+	}
+	return u
+}
+
+func TestTUSUploaderUploadFresh(t *testing.T) {
+	server := newFakeTUSServer()
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestTUSUploader(t, srv.URL)
+	content := []byte("the quick brown fox jumps over the lazy dog")
+
+	if err := u.Upload("https://example.com/fox.pdf", bytes.NewReader(content), int64(len(content)), "/fox.pdf"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if got := server.stored("1"); !bytes.Equal(got, content) {
+		t.Fatalf("server received %q, want %q", got, content)
+	}
+}
+
+func TestTUSUploaderResumeSkipsAlreadyUploadedBytes(t *testing.T) {
+	server := newFakeTUSServer()
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	const uploadID = "partial"
+
+	// Simulate a prior run that already pushed the first half of the file
+	// to the server before being interrupted.
+	alreadySent := content[:20]
+	server.mu.Lock()
+	server.data[uploadID] = append([]byte(nil), alreadySent...)
+	server.mu.Unlock()
+
+	u := newTestTUSUploader(t, srv.URL)
+	sourceURL := "https://example.com/fox.pdf"
+	u.uploads[sourceURL] = srv.URL + "/" + uploadID
+	if err := u.saveState(); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+
+	// Upload is always handed the full content from byte 0, as a fresh
+	// download would produce; resuming must skip past the bytes the
+	// server already has instead of re-sending them at the wrong offset.
+	if err := u.Upload(sourceURL, bytes.NewReader(content), int64(len(content)), "/fox.pdf"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if got := server.stored(uploadID); !bytes.Equal(got, content) {
+		t.Fatalf("server received %q, want %q (resume must not duplicate or corrupt the already-uploaded prefix)", got, content)
+	}
+
+	if _, ok := u.uploads[sourceURL]; ok {
+		t.Fatalf("uploads map still has an entry for %s after a completed upload", sourceURL)
+	}
+}
+
+func TestTUSUploaderResumeKeepsMappingOnTransientHeadError(t *testing.T) {
+	server := newFakeTUSServer()
+	server.headStatus = http.StatusServiceUnavailable
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestTUSUploader(t, srv.URL)
+	sourceURL := "https://example.com/fox.pdf"
+	u.uploads[sourceURL] = srv.URL + "/partial"
+	if err := u.saveState(); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+
+	_, _, err := u.resume(sourceURL)
+	if err == nil {
+		t.Fatal("resume() error = nil, want an error for a transient 503 on HEAD")
+	}
+
+	if _, ok := u.uploads[sourceURL]; !ok {
+		t.Fatal("resume() dropped the persisted upload URL on a transient HEAD error, want it kept so a later retry can still resume")
+	}
+}
+
+func TestTUSUploaderResumeDropsMappingWhenUploadIsGone(t *testing.T) {
+	server := newFakeTUSServer()
+	server.headStatus = http.StatusNotFound
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestTUSUploader(t, srv.URL)
+	sourceURL := "https://example.com/fox.pdf"
+	u.uploads[sourceURL] = srv.URL + "/partial"
+	if err := u.saveState(); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+
+	uploadURL, _, err := u.resume(sourceURL)
+	if err != nil {
+		t.Fatalf("resume() error = %v, want nil (a 404 means start a fresh upload)", err)
+	}
+	if uploadURL != "" {
+		t.Fatalf("resume() uploadURL = %q, want empty so Upload starts a fresh upload", uploadURL)
+	}
+
+	if _, ok := u.uploads[sourceURL]; ok {
+		t.Fatal("resume() kept the persisted upload URL after the server reported the upload gone (404)")
+	}
+}
+
+func TestTUSUploaderSendChunksAdvancesFullSize(t *testing.T) {
+	server := newFakeTUSServer()
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestTUSUploader(t, srv.URL)
+	server.mu.Lock()
+	server.data["direct"] = nil
+	server.mu.Unlock()
+
+	content := []byte("0123456789")
+	if err := u.sendChunks(bytes.NewReader(content), int64(len(content)), 0, srv.URL+"/direct"); err != nil {
+		t.Fatalf("sendChunks() error = %v", err)
+	}
+
+	if got := server.stored("direct"); !bytes.Equal(got, content) {
+		t.Fatalf("server received %q, want %q", got, content)
+	}
+}
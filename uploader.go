@@ -0,0 +1,23 @@
+package main
+
+import "io"
+
+// Uploader abstracts the destination a downloaded recipe PDF is stored to,
+// so Scrapper.Download does not need to know whether it is talking to a
+// WebDAV server or a TUS endpoint.
+type Uploader interface {
+	// EnsureFolder makes sure folder exists at the destination, creating it
+	// (and any missing parents) if needed. Implementations for which the
+	// destination has no folder hierarchy may treat this as a no-op.
+	EnsureFolder(folder string) error
+
+	// Exists reports whether destPath has already been fully uploaded, so
+	// Download can skip re-fetching it.
+	Exists(destPath string) (bool, error)
+
+	// Upload reads size bytes from content and stores them at destPath.
+	// sourceURL identifies where the content was downloaded from, which
+	// implementations that support resuming interrupted uploads use as the
+	// key to persist progress.
+	Upload(sourceURL string, content io.Reader, size int64, destPath string) error
+}
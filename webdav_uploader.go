@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebDAVUploader is the current/default Uploader implementation: it stores
+// files on a Nextcloud WebDAV share, using the V2 chunked upload protocol
+// for every upload whose size is known, so large files are not buffered
+// fully in RAM.
+//
+// gowebdav.Client is not safe for concurrent use (see
+// https://github.com/studio-b12/gowebdav/issues/19), so every call into dav
+// is serialized through davMu; this lets DownloadAll run uploads to
+// different destinations concurrently without racing on the shared client.
+type WebDAVUploader struct {
+	dav       *gowebdav.Client
+	davMu     sync.Mutex
+	baseUrl   string
+	username  string
+	password  string
+	client    *http.Client
+	chunkSize int64
+}
+
+func NewWebDAVUploader(davUrl, username, password string, timeout time.Duration, chunkSize int64) (*WebDAVUploader, error) {
+	dav := gowebdav.NewClient(davUrl, username, password)
+	if err := dav.Connect(); err != nil {
+		return nil, err
+	}
+
+	return &WebDAVUploader{
+		dav:       dav,
+		baseUrl:   strings.TrimRight(davUrl, "/"),
+		username:  username,
+		password:  password,
+		chunkSize: chunkSize,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+func (w *WebDAVUploader) EnsureFolder(folder string) error {
+	w.davMu.Lock()
+	defer w.davMu.Unlock()
+
+	return w.dav.MkdirAll(folder, 0755)
+}
+
+func (w *WebDAVUploader) Exists(destPath string) (bool, error) {
+	w.davMu.Lock()
+	defer w.davMu.Unlock()
+
+	_, err := w.dav.Stat(destPath)
+	return err == nil, nil
+}
+
+// ReadManifest implements ContentAddressableStore.
+func (w *WebDAVUploader) ReadManifest(manifestPath string) ([]byte, error) {
+	w.davMu.Lock()
+	defer w.davMu.Unlock()
+
+	data, err := w.dav.Read(manifestPath)
+	if err != nil {
+		if gowebdav.IsErrNotFound(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// WriteManifest implements ContentAddressableStore.
+func (w *WebDAVUploader) WriteManifest(manifestPath string, data []byte) error {
+	w.davMu.Lock()
+	defer w.davMu.Unlock()
+
+	return w.dav.Write(manifestPath, data, 0644)
+}
+
+// Copy implements ContentAddressableStore, issuing a WebDAV COPY so
+// duplicate content can be deduplicated without re-uploading bytes.
+func (w *WebDAVUploader) Copy(srcPath, destPath string) error {
+	w.davMu.Lock()
+	defer w.davMu.Unlock()
+
+	return w.dav.Copy(srcPath, destPath, true)
+}
+
+func (w *WebDAVUploader) Upload(sourceURL string, content io.Reader, size int64, destPath string) error {
+	// HEADS UP !
+	//
+	// Because of a potential bug with the default Nextcloud configuration, a
+	// plain WriteStream() can't be used here: the golang net/http library
+	// only sets Content-Length when the body is a bytes.Reader, strings.Reader
+	// or bytes.Buffer, and falls back to chunked encoding otherwise. The
+	// default Nginx configuration for Nextcloud does not like chunked
+	// encoding...
+	//
+	// See https://github.com/photoprism/photoprism/issues/443#issuecomment-685608490
+	// and https://github.com/studio-b12/gowebdav/issues/35
+	//
+	// Uploading through the V2 chunking protocol sidesteps the issue
+	// entirely since every chunk PUT carries an explicit Content-Length, and
+	// it avoids buffering the whole (possibly multi-hundred-MB) PDF in RAM.
+	if size > 0 {
+		return w.uploadChunked(content, size, destPath)
+	}
+
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	w.davMu.Lock()
+	defer w.davMu.Unlock()
+	return w.dav.WriteStream(destPath, bytes.NewReader(buf), 0644)
+}
+
+// uploadChunked streams content (size bytes) to destPath using Nextcloud's
+// V2 chunked upload protocol: a temporary upload directory is created under
+// /remote.php/dav/uploads/<user>/<transfer-id>/, chunks are PUT to
+// sequentially numbered paths inside it, then a final MOVE assembles them
+// into destPath.
+func (w *WebDAVUploader) uploadChunked(content io.Reader, size int64, destPath string) error {
+	transferDir := fmt.Sprintf("/remote.php/dav/uploads/%s/%d", w.username, time.Now().UnixNano())
+
+	if err := w.davRequest("MKCOL", transferDir, nil, nil); err != nil {
+		return fmt.Errorf("cannot create upload directory: %w", err)
+	}
+
+	cleanup := func() {
+		if cerr := w.davRequest("DELETE", transferDir, nil, nil); cerr != nil {
+			log.Printf("Cannot clean up upload directory %s: %s", transferDir, cerr)
+		}
+	}
+
+	buf := make([]byte, w.chunkSize)
+	for chunk := 1; ; chunk++ {
+		n, err := io.ReadFull(content, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			cleanup()
+			return err
+		}
+		if n > 0 {
+			chunkPath := fmt.Sprintf("%s/%05d", transferDir, chunk)
+			headers := map[string]string{"Content-Length": strconv.Itoa(n)}
+			if perr := w.davRequest("PUT", chunkPath, bytes.NewReader(buf[:n]), headers); perr != nil {
+				cleanup()
+				return perr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	if err := w.assembleChunks(transferDir, destPath, size); err != nil {
+		cleanup()
+		return err
+	}
+
+	return nil
+}
+
+// assembleChunks issues the final MOVE that merges an uploaded chunk set
+// into its destination, retrying with exponential backoff while Nextcloud
+// reports the destination as 423 Locked (the background chunk-merge job can
+// hold the lock for a short while). A 404 seen after a prior 423 is treated
+// as success: the merge may have completed in the background.
+func (w *WebDAVUploader) assembleChunks(transferDir, destPath string, size int64) error {
+	headers := map[string]string{
+		"Destination":     w.baseUrl + "/remote.php/dav/files/" + w.username + destPath,
+		"OC-Total-Length": strconv.FormatInt(size, 10),
+		"Overwrite":       "T",
+	}
+
+	wasLocked := false
+	backoff := time.Second
+	for attempt := 0; attempt < 6; attempt++ {
+		err := w.davRequest("MOVE", transferDir+"/.file", nil, headers)
+		if err == nil {
+			return nil
+		}
+
+		var statusErr *davStatusError
+		if asStatusError(err, &statusErr) {
+			if statusErr.StatusCode == http.StatusLocked {
+				wasLocked = true
+				time.Sleep(backoff)
+				backoff *= 2
+				continue
+			}
+			if statusErr.StatusCode == http.StatusNotFound && wasLocked {
+				return nil
+			}
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("upload still locked after retries: %s", destPath)
+}
+
+// davStatusError carries the HTTP status code of a failed WebDAV request so
+// callers can branch on it (e.g. 423 Locked vs. 404 Not Found).
+type davStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *davStatusError) Error() string {
+	return fmt.Sprintf("webdav request failed: %s", e.Status)
+}
+
+func (e *davStatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+func asStatusError(err error, target **davStatusError) bool {
+	se, ok := err.(*davStatusError)
+	if ok {
+		*target = se
+	}
+	return ok
+}
+
+// davRequest issues a raw WebDAV request against w.baseUrl+reqPath, using
+// the same credentials as w.dav. It is used for operations gowebdav.Client
+// does not expose (MKCOL with extended methods, chunked PUTs, MOVE with
+// custom headers).
+func (w *WebDAVUploader) davRequest(method, reqPath string, body io.Reader, headers map[string]string) error {
+	req, err := http.NewRequest(method, w.baseUrl+reqPath, body)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(w.username, w.password)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &davStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebDAVServer backs the subset of the WebDAV V2 chunked upload
+// protocol uploadChunked/assembleChunks drive: MKCOL to open a transfer,
+// PUT for each chunk, MOVE to assemble, DELETE to clean up on failure.
+type fakeWebDAVServer struct {
+	mu       sync.Mutex
+	chunks   map[string][]byte
+	deleted  []string
+	moveHits int
+	// moveStatus is called for each MOVE request and returns the status
+	// code to answer with; nil means "always succeed".
+	moveStatus func(hit int) int
+	// getStatus is called for each GET request and returns the status
+	// code and body to answer with; nil means "serve whatever a prior
+	// PUT stored at this path, or 404 if nothing was ever PUT there."
+	getStatus func(path string) (int, []byte)
+}
+
+func newFakeWebDAVServer() *fakeWebDAVServer {
+	return &fakeWebDAVServer{chunks: map[string][]byte{}}
+}
+
+func (f *fakeWebDAVServer) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			w.WriteHeader(http.StatusOK)
+
+		case "MKCOL":
+			w.WriteHeader(http.StatusCreated)
+
+		case "PUT":
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			f.mu.Lock()
+			f.chunks[r.URL.Path] = body
+			f.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+
+		case "GET":
+			f.mu.Lock()
+			status, body := http.StatusNotFound, []byte(nil)
+			if f.getStatus != nil {
+				status, body = f.getStatus(r.URL.Path)
+			} else if stored, ok := f.chunks[r.URL.Path]; ok {
+				status, body = http.StatusOK, stored
+			}
+			f.mu.Unlock()
+
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write(body)
+
+		case "MOVE":
+			f.mu.Lock()
+			hit := f.moveHits
+			f.moveHits++
+			status := http.StatusCreated
+			if f.moveStatus != nil {
+				status = f.moveStatus(hit)
+			}
+			f.mu.Unlock()
+			w.WriteHeader(status)
+
+		case "DELETE":
+			f.mu.Lock()
+			f.deleted = append(f.deleted, r.URL.Path)
+			f.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (f *fakeWebDAVServer) assembled() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for k := range f.chunks {
+		keys = append(keys, k)
+	}
+	// Chunks are zero-padded 5-digit sequence numbers, so a lexicographic
+	// sort matches upload order.
+	for i := 0; i < len(keys); i++ {
+		for j := i + 1; j < len(keys); j++ {
+			if keys[j] < keys[i] {
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+		}
+	}
+
+	var out []byte
+	for _, k := range keys {
+		out = append(out, f.chunks[k]...)
+	}
+	return out
+}
+
+func newTestWebDAVUploader(t *testing.T, serverURL string, chunkSize int64) *WebDAVUploader {
+	t.Helper()
+	u, err := NewWebDAVUploader(serverURL, "testuser", "testpass", 0, chunkSize)
+	if err != nil {
+		t.Fatalf("NewWebDAVUploader() error = %v", err)
+	}
+	return u
+}
+
+func TestWebDAVUploaderUploadChunkedAssemblesInOrder(t *testing.T) {
+	server := newFakeWebDAVServer()
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+	content := []byte("hello world!")
+
+	if err := u.Upload("https://example.com/greeting.pdf", bytes.NewReader(content), int64(len(content)), "/greeting.pdf"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if got := server.assembled(); !bytes.Equal(got, content) {
+		t.Fatalf("assembled chunks = %q, want %q", got, content)
+	}
+
+	server.mu.Lock()
+	deleted := len(server.deleted)
+	server.mu.Unlock()
+	if deleted != 0 {
+		t.Fatalf("DELETE called %d times on a successful upload, want 0", deleted)
+	}
+}
+
+func TestWebDAVUploaderUploadChunkedCleansUpOnChunkFailure(t *testing.T) {
+	server := newFakeWebDAVServer()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		server.handler()(w, r)
+	}))
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+	content := []byte("hello world!")
+
+	err := u.Upload("https://example.com/greeting.pdf", bytes.NewReader(content), int64(len(content)), "/greeting.pdf")
+	if err == nil {
+		t.Fatal("Upload() error = nil, want an error from the failed chunk PUT")
+	}
+
+	server.mu.Lock()
+	deleted := len(server.deleted)
+	server.mu.Unlock()
+	if deleted != 1 {
+		t.Fatalf("DELETE called %d times after a failed chunk PUT, want 1 (cleanup of the transfer directory)", deleted)
+	}
+}
+
+func TestWebDAVUploaderAssembleChunksRetriesOnLockedThenTreatsNotFoundAsSuccess(t *testing.T) {
+	server := newFakeWebDAVServer()
+	server.moveStatus = func(hit int) int {
+		if hit == 0 {
+			return http.StatusLocked
+		}
+		// The background chunk-merge finished: the transfer directory is
+		// gone by the time we retry.
+		return http.StatusNotFound
+	}
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+
+	start := time.Now()
+	err := u.assembleChunks("/remote.php/dav/uploads/testuser/1", "/greeting.pdf", 12)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("assembleChunks() error = %v, want nil (404 after a prior 423 is treated as success)", err)
+	}
+	if elapsed < 900*time.Millisecond {
+		t.Fatalf("assembleChunks() returned after %s, want it to have backed off before retrying", elapsed)
+	}
+}
+
+func TestWebDAVUploaderReadManifestReturnsContent(t *testing.T) {
+	server := newFakeWebDAVServer()
+	server.chunks["/manifest.json"] = []byte(`[{"sourceURL":"a"}]`)
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+
+	got, err := u.ReadManifest("/manifest.json")
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if !bytes.Equal(got, server.chunks["/manifest.json"]) {
+		t.Fatalf("ReadManifest() = %q, want %q", got, server.chunks["/manifest.json"])
+	}
+}
+
+func TestWebDAVUploaderReadManifestMissingIsNotExist(t *testing.T) {
+	server := newFakeWebDAVServer()
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+
+	_, err := u.ReadManifest("/manifest.json")
+	if !os.IsNotExist(err) {
+		t.Fatalf("ReadManifest() error = %v, want os.IsNotExist(err) == true for a 404", err)
+	}
+}
+
+func TestWebDAVUploaderReadManifestPropagatesTransientError(t *testing.T) {
+	server := newFakeWebDAVServer()
+	server.getStatus = func(path string) (int, []byte) { return http.StatusServiceUnavailable, nil }
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+
+	_, err := u.ReadManifest("/manifest.json")
+	if err == nil {
+		t.Fatal("ReadManifest() error = nil, want an error for a 503")
+	}
+	// A transient failure must not be mistaken for "manifest doesn't
+	// exist yet": doing so makes the next write silently overwrite the
+	// real dedup history with an empty manifest.
+	if os.IsNotExist(err) {
+		t.Fatalf("ReadManifest() error = %v, want os.IsNotExist(err) == false for a transient 503", err)
+	}
+}
+
+func TestWebDAVUploaderWriteManifest(t *testing.T) {
+	server := newFakeWebDAVServer()
+	srv := httptest.NewServer(server.handler())
+	defer srv.Close()
+
+	u := newTestWebDAVUploader(t, srv.URL, 4)
+	data := []byte(`[{"sourceURL":"a"}]`)
+
+	if err := u.WriteManifest("/manifest.json", data); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+
+	server.mu.Lock()
+	got := server.chunks["/manifest.json"]
+	server.mu.Unlock()
+	if !bytes.Equal(got, data) {
+		t.Fatalf("server received %q, want %q", got, data)
+	}
+}